@@ -0,0 +1,232 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2016 winlin
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	oh "github.com/ossrs/go-oryx-lib/http"
+	"go.uber.org/zap"
+)
+
+// adminServer exposes runtime backend management and Prometheus-format
+// metrics over HTTP:
+//
+//	GET    /api/v1/backends          list all backends and their stats.
+//	POST   /api/v1/backends          add a backend, body {"addr":"rtmp://..."}.
+//	DELETE /api/v1/backends?addr=…   remove a backend.
+//	POST   /api/v1/backends/drain    stop new dispatch, body {"addr":"rtmp://..."}.
+//	PUT    /api/v1/loglevel          change log verbosity, body {"level":"debug"}.
+//	GET    /api/v1/status            {"draining": bool}, true during graceful shutdown.
+//	GET    /metrics                  Prometheus exposition format.
+//
+// Backend addresses (e.g. tcp://127.0.0.1:1935) are never spliced into the
+// URL path: they contain "://", and http.ServeMux path-cleans a bare "//"
+// into "/" and 301-redirects instead of invoking the handler. addr always
+// travels as a query parameter or a JSON body field instead.
+type adminServer struct {
+	reg        *backendRegistry
+	setLevel   func(string) error
+	isDraining func() bool
+	log        *zap.Logger
+	srv        *http.Server
+}
+
+func NewAdminServer(listen string, reg *backendRegistry, setLevel func(string) error, isDraining func() bool, log *zap.Logger) *adminServer {
+	v := &adminServer{reg: reg, setLevel: setLevel, isDraining: isDraining, log: log}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/backends", v.handleBackends)
+	mux.HandleFunc("/api/v1/backends/drain", v.handleBackendDrain)
+	mux.HandleFunc("/api/v1/loglevel", v.handleLogLevel)
+	mux.HandleFunc("/api/v1/status", v.handleStatus)
+	mux.HandleFunc("/metrics", v.handleMetrics)
+	v.srv = &http.Server{Addr: listen, Handler: mux}
+
+	return v
+}
+
+func (v *adminServer) ListenAndServe(ctx context.Context) error {
+	v.log.Info("admin api listen", zap.String("addr", v.srv.Addr))
+	if err := v.srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func (v *adminServer) Close() error {
+	return v.srv.Close()
+}
+
+// backendView is the JSON shape returned by the admin API for one backend.
+type backendView struct {
+	Addr       string `json:"addr"`
+	Up         bool   `json:"up"`
+	Draining   bool   `json:"draining"`
+	Conns      int64  `json:"connections"`
+	BytesIn    int64  `json:"bytes_in"`
+	BytesOut   int64  `json:"bytes_out"`
+	Reconnects int64  `json:"reconnects"`
+	RttMs      int64  `json:"rtt_ms"`
+}
+
+func viewOf(b *backendStats) backendView {
+	return backendView{
+		Addr:       b.addr,
+		Up:         b.IsUp(),
+		Draining:   b.IsDraining(),
+		Conns:      atomic.LoadInt64(&b.conns),
+		BytesIn:    atomic.LoadInt64(&b.bytesIn),
+		BytesOut:   atomic.LoadInt64(&b.bytesOut),
+		Reconnects: atomic.LoadInt64(&b.reconnects),
+		RttMs:      atomic.LoadInt64(&b.lastRttMs),
+	}
+}
+
+func (v *adminServer) handleBackends(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	switch r.Method {
+	case http.MethodGet:
+		all := v.reg.All()
+		views := make([]backendView, len(all))
+		for i, b := range all {
+			views[i] = viewOf(b)
+		}
+		oh.WriteData(ctx, w, r, views)
+	case http.MethodPost:
+		var body struct {
+			Addr string `json:"addr"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Addr == "" {
+			oh.WriteError(ctx, w, r, fmt.Errorf("invalid backend, err is %v", err))
+			return
+		}
+		v.reg.Add(body.Addr)
+		oh.Success(ctx, w, r)
+	case http.MethodDelete:
+		addr := r.URL.Query().Get("addr")
+		if addr == "" {
+			oh.WriteError(ctx, w, r, fmt.Errorf("missing addr"))
+			return
+		}
+		if b := v.reg.Get(addr); b == nil {
+			oh.WriteError(ctx, w, r, fmt.Errorf("backend %v not found", addr))
+			return
+		}
+		v.reg.Remove(addr)
+		oh.Success(ctx, w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (v *adminServer) handleBackendDrain(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+
+	var body struct {
+		Addr string `json:"addr"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Addr == "" {
+		oh.WriteError(ctx, w, r, fmt.Errorf("invalid backend, err is %v", err))
+		return
+	}
+
+	b := v.reg.Get(body.Addr)
+	if b == nil {
+		oh.WriteError(ctx, w, r, fmt.Errorf("backend %v not found", body.Addr))
+		return
+	}
+	b.setDraining(true)
+	oh.Success(ctx, w, r)
+}
+
+func (v *adminServer) handleLogLevel(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodPut {
+		http.NotFound(w, r)
+		return
+	}
+
+	var body struct {
+		Level string `json:"level"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Level == "" {
+		oh.WriteError(ctx, w, r, fmt.Errorf("invalid level, err is %v", err))
+		return
+	}
+	if err := v.setLevel(body.Level); err != nil {
+		oh.WriteError(ctx, w, r, err)
+		return
+	}
+	oh.Success(ctx, w, r)
+}
+
+func (v *adminServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+
+	oh.WriteData(ctx, w, r, struct {
+		Draining bool `json:"draining"`
+	}{Draining: v.isDraining()})
+}
+
+func (v *adminServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	oh.SetHeader(w)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	draining := 0
+	if v.isDraining() {
+		draining = 1
+	}
+	fmt.Fprintf(w, "rtmplb_draining %v\n", draining)
+
+	for _, b := range v.reg.All() {
+		up := 0
+		if b.IsUp() {
+			up = 1
+		}
+		fmt.Fprintf(w, "rtmplb_backend_up{backend=%q} %v\n", b.addr, up)
+		fmt.Fprintf(w, "rtmplb_connections{backend=%q} %v\n", b.addr, atomic.LoadInt64(&b.conns))
+		fmt.Fprintf(w, "rtmplb_bytes_in_total{backend=%q} %v\n", b.addr, atomic.LoadInt64(&b.bytesIn))
+		fmt.Fprintf(w, "rtmplb_bytes_out_total{backend=%q} %v\n", b.addr, atomic.LoadInt64(&b.bytesOut))
+		fmt.Fprintf(w, "rtmplb_reconnects_total{backend=%q} %v\n", b.addr, atomic.LoadInt64(&b.reconnects))
+		fmt.Fprintf(w, "rtmplb_backend_rtt_ms{backend=%q} %v\n", b.addr, atomic.LoadInt64(&b.lastRttMs))
+	}
+}
@@ -0,0 +1,256 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2016 winlin
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// backendStats is the live state of a single backend: whether the background
+// prober currently considers it reachable, whether an operator has asked it
+// to drain, its current live connection count, and the cumulative counters
+// surfaced by the admin API and /metrics.
+type backendStats struct {
+	addr string
+
+	up         int32 // atomic bool, 1 = reachable.
+	draining   int32 // atomic bool, 1 = stop new dispatch.
+	conns      int64 // atomic, current live connections.
+	bytesIn    int64 // atomic, cumulative client->backend bytes.
+	bytesOut   int64 // atomic, cumulative backend->client bytes.
+	reconnects int64 // atomic, cumulative failed dial attempts.
+	lastRttMs  int64 // atomic, last successful probe RTT in milliseconds.
+}
+
+func newBackendStats(addr string) *backendStats {
+	return &backendStats{addr: addr, up: 1}
+}
+
+func (v *backendStats) IsUp() bool       { return atomic.LoadInt32(&v.up) != 0 }
+func (v *backendStats) IsDraining() bool { return atomic.LoadInt32(&v.draining) != 0 }
+
+// Available reports whether a balancer may dispatch a new connection here.
+func (v *backendStats) Available() bool { return v.IsUp() && !v.IsDraining() }
+
+func (v *backendStats) setUp(up bool) {
+	n := int32(0)
+	if up {
+		n = 1
+	}
+	atomic.StoreInt32(&v.up, n)
+}
+
+func (v *backendStats) setDraining(draining bool) {
+	n := int32(0)
+	if draining {
+		n = 1
+	}
+	atomic.StoreInt32(&v.draining, n)
+}
+
+// backendRegistry owns the live set of backends and their stats. Membership
+// changes (runtime add/remove via the admin API) are guarded by a mutex;
+// the hot-path counters on backendStats are plain atomics.
+type backendRegistry struct {
+	mu       sync.RWMutex
+	order    []string
+	backends map[string]*backendStats
+}
+
+func newBackendRegistry(addrs []string) *backendRegistry {
+	v := &backendRegistry{backends: map[string]*backendStats{}}
+	for _, addr := range addrs {
+		v.Add(addr)
+	}
+	return v
+}
+
+// Add registers addr if it is not already known. A no-op if it is.
+func (v *backendRegistry) Add(addr string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if _, ok := v.backends[addr]; ok {
+		return
+	}
+	v.order = append(v.order, addr)
+	v.backends[addr] = newBackendStats(addr)
+}
+
+// Remove drops addr from the registry; in-flight streams already dispatched
+// to it are unaffected, only future Pick calls will stop returning it.
+func (v *backendRegistry) Remove(addr string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	delete(v.backends, addr)
+	for i, a := range v.order {
+		if a == addr {
+			v.order = append(v.order[:i], v.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func (v *backendRegistry) Get(addr string) *backendStats {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.backends[addr]
+}
+
+// All returns every known backend's stats, in registration order.
+func (v *backendRegistry) All() []*backendStats {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	out := make([]*backendStats, 0, len(v.order))
+	for _, addr := range v.order {
+		out = append(out, v.backends[addr])
+	}
+	return out
+}
+
+// Available returns the addresses of backends a Balancer may dispatch to:
+// healthy, per the prober, and not draining.
+func (v *backendRegistry) Available() []string {
+	var out []string
+	for _, b := range v.All() {
+		if b.Available() {
+			out = append(out, b.addr)
+		}
+	}
+	return out
+}
+
+// availableFrom filters pool down to the addresses that are both registered
+// and currently Available, preserving pool's order. It lets the router apply
+// the same health/drain filtering to a routing rule's static backend list
+// that Available() already applies to the default pool.
+func (v *backendRegistry) availableFrom(pool []string) []string {
+	var out []string
+	for _, addr := range pool {
+		if b := v.Get(addr); b != nil && b.Available() {
+			out = append(out, addr)
+		}
+	}
+	return out
+}
+
+// Balancer selects a backend out of a registry's currently-available
+// backends for a new connection. key is the routed stream key, consulted
+// only by the consistent-hash strategy.
+type Balancer interface {
+	Pick(reg *backendRegistry, key string) string
+}
+
+const (
+	BalancerRoundRobin     = "round_robin"
+	BalancerLeastConn      = "least_conn"
+	BalancerConsistentHash = "consistent_hash"
+	BalancerWeighted       = "weighted"
+)
+
+// NewBalancer builds a Balancer for the given strategy name, defaulting to
+// round-robin for an empty or unknown value.
+func NewBalancer(kind string, weights map[string]int) Balancer {
+	switch strings.ToLower(kind) {
+	case BalancerLeastConn:
+		return &leastConnBalancer{}
+	case BalancerConsistentHash:
+		return &consistentHashBalancer{}
+	case BalancerWeighted:
+		return &weightedBalancer{weights: weights}
+	default:
+		return &roundRobinBalancer{}
+	}
+}
+
+type roundRobinBalancer struct {
+	next uint32
+}
+
+func (v *roundRobinBalancer) Pick(reg *backendRegistry, key string) string {
+	up := reg.Available()
+	if len(up) == 0 {
+		return ""
+	}
+	i := atomic.AddUint32(&v.next, 1) - 1
+	return up[i%uint32(len(up))]
+}
+
+type leastConnBalancer struct{}
+
+func (v *leastConnBalancer) Pick(reg *backendRegistry, key string) string {
+	best := ""
+	bestConns := int64(-1)
+	for _, b := range reg.All() {
+		if !b.Available() {
+			continue
+		}
+		if c := atomic.LoadInt64(&b.conns); bestConns < 0 || c < bestConns {
+			best, bestConns = b.addr, c
+		}
+	}
+	return best
+}
+
+// consistentHashBalancer rebuilds a hashRing from the currently-available
+// backends on every Pick; good enough at rtmplb's connection rates, and it
+// means the ring always reflects runtime add/remove/drain without needing
+// a change-notification path of its own.
+type consistentHashBalancer struct{}
+
+func (v *consistentHashBalancer) Pick(reg *backendRegistry, key string) string {
+	up := reg.Available()
+	if len(up) == 0 {
+		return ""
+	}
+	return newHashRing(up).Pick(key)
+}
+
+type weightedBalancer struct {
+	weights map[string]int
+	next    uint32
+}
+
+func (v *weightedBalancer) Pick(reg *backendRegistry, key string) string {
+	var expanded []string
+	for _, addr := range reg.Available() {
+		weight := v.weights[addr]
+		if weight <= 0 {
+			weight = 1
+		}
+		for i := 0; i < weight; i++ {
+			expanded = append(expanded, addr)
+		}
+	}
+	if len(expanded) == 0 {
+		return ""
+	}
+	i := atomic.AddUint32(&v.next, 1) - 1
+	return expanded[i%uint32(len(expanded))]
+}
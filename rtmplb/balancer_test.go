@@ -0,0 +1,155 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2016 winlin
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestBackendRegistryAddIsIdempotent(t *testing.T) {
+	reg := newBackendRegistry([]string{"tcp://127.0.0.1:1935"})
+	reg.Add("tcp://127.0.0.1:1935")
+	reg.Add("tcp://127.0.0.1:1936")
+
+	all := reg.All()
+	if len(all) != 2 {
+		t.Fatalf("got %v backends, want 2: %+v", len(all), all)
+	}
+	if all[0].addr != "tcp://127.0.0.1:1935" || all[1].addr != "tcp://127.0.0.1:1936" {
+		t.Fatalf("unexpected order: %+v", all)
+	}
+}
+
+func TestBackendRegistryRemove(t *testing.T) {
+	reg := newBackendRegistry([]string{"tcp://a:1", "tcp://b:2"})
+	reg.Remove("tcp://a:1")
+
+	if reg.Get("tcp://a:1") != nil {
+		t.Fatalf("tcp://a:1 should have been removed")
+	}
+	if len(reg.All()) != 1 {
+		t.Fatalf("got %v backends, want 1", len(reg.All()))
+	}
+}
+
+func TestBackendRegistryAvailable(t *testing.T) {
+	reg := newBackendRegistry([]string{"tcp://a:1", "tcp://b:2", "tcp://c:3"})
+	reg.Get("tcp://b:2").setUp(false)
+	reg.Get("tcp://c:3").setDraining(true)
+
+	avail := reg.Available()
+	if len(avail) != 1 || avail[0] != "tcp://a:1" {
+		t.Fatalf("got %v, want only tcp://a:1", avail)
+	}
+}
+
+func TestRoundRobinBalancerCyclesEvenly(t *testing.T) {
+	reg := newBackendRegistry([]string{"tcp://a:1", "tcp://b:2"})
+	b := NewBalancer(BalancerRoundRobin, nil)
+
+	got := make([]string, 4)
+	for i := range got {
+		got[i] = b.Pick(reg, "")
+	}
+	want := []string{"tcp://a:1", "tcp://b:2", "tcp://a:1", "tcp://b:2"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("pick %v = %v, want %v (full sequence %v)", i, got[i], want[i], got)
+		}
+	}
+}
+
+func TestRoundRobinBalancerSkipsUnavailable(t *testing.T) {
+	reg := newBackendRegistry([]string{"tcp://a:1", "tcp://b:2"})
+	reg.Get("tcp://b:2").setDraining(true)
+	b := NewBalancer(BalancerRoundRobin, nil)
+
+	for i := 0; i < 4; i++ {
+		if got := b.Pick(reg, ""); got != "tcp://a:1" {
+			t.Fatalf("pick %v = %v, want tcp://a:1", i, got)
+		}
+	}
+}
+
+func TestRoundRobinBalancerConcurrentPickDoesNotRace(t *testing.T) {
+	reg := newBackendRegistry([]string{"tcp://a:1", "tcp://b:2", "tcp://c:3"})
+	b := NewBalancer(BalancerRoundRobin, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.Pick(reg, "")
+		}()
+	}
+	wg.Wait()
+}
+
+func TestLeastConnBalancerPicksFewestConns(t *testing.T) {
+	reg := newBackendRegistry([]string{"tcp://a:1", "tcp://b:2"})
+	reg.Get("tcp://a:1").conns = 5
+	reg.Get("tcp://b:2").conns = 1
+
+	b := NewBalancer(BalancerLeastConn, nil)
+	if got := b.Pick(reg, ""); got != "tcp://b:2" {
+		t.Fatalf("got %v, want tcp://b:2", got)
+	}
+}
+
+func TestConsistentHashBalancerIsStableForSameKey(t *testing.T) {
+	reg := newBackendRegistry([]string{"tcp://a:1", "tcp://b:2", "tcp://c:3"})
+	b := NewBalancer(BalancerConsistentHash, nil)
+
+	first := b.Pick(reg, "stream1")
+	for i := 0; i < 10; i++ {
+		if got := b.Pick(reg, "stream1"); got != first {
+			t.Fatalf("pick %v for stream1 = %v, want stable %v", i, got, first)
+		}
+	}
+}
+
+func TestWeightedBalancerRespectsWeights(t *testing.T) {
+	reg := newBackendRegistry([]string{"tcp://a:1", "tcp://b:2"})
+	b := NewBalancer(BalancerWeighted, map[string]int{"tcp://a:1": 3, "tcp://b:2": 1})
+
+	counts := map[string]int{}
+	for i := 0; i < 8; i++ {
+		counts[b.Pick(reg, "")]++
+	}
+	if counts["tcp://a:1"] != 6 || counts["tcp://b:2"] != 2 {
+		t.Fatalf("got %+v, want a:6 b:2 over two full weighted rounds", counts)
+	}
+}
+
+func TestBalancerPickOnEmptyRegistryReturnsEmpty(t *testing.T) {
+	reg := newBackendRegistry(nil)
+	for _, kind := range []string{BalancerRoundRobin, BalancerLeastConn, BalancerConsistentHash, BalancerWeighted} {
+		if got := NewBalancer(kind, nil).Pick(reg, "x"); got != "" {
+			t.Fatalf("%v.Pick on empty registry = %q, want empty", kind, got)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2016 winlin
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import "sync"
+
+// copyBufSize matches the buffer size io.Copy itself would otherwise
+// allocate per call; pooling it is only worthwhile on the platforms whose
+// platformCopy (see copy_linux.go / copy_other.go) actually
+// uses it instead of a kernel-side zero-copy path.
+const copyBufSize = 32 * 1024
+
+// copyBufPool hands out the fallback copy buffer for platformCopy, so
+// proxying at high fan-out does not allocate a fresh 32 KiB buffer per
+// connection per direction the way a bare io.Copy would.
+var copyBufPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, copyBufSize)
+		return &b
+	},
+}
+
+// platformCopy streams src into dst (both the raw, unwrapped *net.TCPConn on
+// either side of a proxied RTMP connection) and reports the bytes copied.
+// Its implementation is platform-specific: see copy_linux.go for the
+// splice(2) fast path, and copy_other.go for the portable pooled-buffer
+// fallback used everywhere else.
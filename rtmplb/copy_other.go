@@ -0,0 +1,53 @@
+//go:build !linux
+
+/*
+The MIT License (MIT)
+
+Copyright (c) 2016 winlin
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"io"
+	"net"
+)
+
+// platformCopy is the portable fallback for every platform other than
+// Linux (copy_linux.go). An earlier version of this file carved out a
+// readv(2)/writev(2) fast path for darwin and freebsd via
+// golang.org/x/sys/unix, but golang.org/x/sys/unix only implements
+// Readv/Writev on linux and illumos/solaris — a real GOOS=darwin and
+// GOOS=freebsd build both fail with "undefined: unix.Readv" — so that path
+// never compiled on either target and has been removed; darwin and freebsd
+// both get this pooled-buffer copy loop instead. dst and src are wrapped in
+// plain io.Writer / io.Reader so io.CopyBuffer can't see their
+// ReaderFrom/WriterTo methods and silently discard the pooled buffer in
+// favor of its own allocation.
+func platformCopy(dst, src *net.TCPConn) (int64, error) {
+	bufp := copyBufPool.Get().(*[]byte)
+	defer copyBufPool.Put(bufp)
+
+	return io.CopyBuffer(onlyWriter{dst}, onlyReader{src}, *bufp)
+}
+
+type onlyReader struct{ io.Reader }
+type onlyWriter struct{ io.Writer }
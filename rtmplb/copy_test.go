@@ -0,0 +1,159 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2016 winlin
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+)
+
+// tcpPipe returns a connected pair of loopback *net.TCPConn, since
+// platformCopy's signature (unlike io.Copy) is pinned to *net.TCPConn so
+// the Linux build can reach TCPConn.ReadFrom's splice(2) fast path.
+func tcpPipe(tb testing.TB) (a, b *net.TCPConn) {
+	tb.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("listen failed: %v", err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			tb.Errorf("accept failed: %v", err)
+		}
+		accepted <- c
+	}()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		tb.Fatalf("dial failed: %v", err)
+	}
+	server := <-accepted
+
+	return server.(*net.TCPConn), client.(*net.TCPConn)
+}
+
+func TestPlatformCopyCopiesAllBytes(t *testing.T) {
+	src, srcPeer := tcpPipe(t)
+	defer src.Close()
+	defer srcPeer.Close()
+	dst, dstPeer := tcpPipe(t)
+	defer dst.Close()
+	defer dstPeer.Close()
+
+	payload := bytes.Repeat([]byte("rtmplb"), 10000)
+	go func() {
+		srcPeer.Write(payload)
+		srcPeer.Close()
+	}()
+
+	got := make(chan []byte, 1)
+	go func() {
+		b, _ := io.ReadAll(dstPeer)
+		got <- b
+	}()
+
+	n, err := platformCopy(dst, src)
+	if err != nil {
+		t.Fatalf("platformCopy failed: %v", err)
+	}
+	if n != int64(len(payload)) {
+		t.Fatalf("copied %v bytes, want %v", n, len(payload))
+	}
+	dst.Close()
+
+	if b := <-got; !bytes.Equal(b, payload) {
+		t.Fatalf("dst received %v bytes, want the original %v byte payload", len(b), len(payload))
+	}
+}
+
+// poolOnlyReader and poolOnlyWriter strip a *net.TCPConn down to plain
+// io.Reader/io.Writer, the same trick copy_other.go's platformCopy uses to
+// stop io.CopyBuffer from bypassing copyBufPool via ReaderFrom/WriterTo.
+type poolOnlyReader struct{ io.Reader }
+type poolOnlyWriter struct{ io.Writer }
+
+// pooledBufferCopy is the portable fallback path (see copy_other.go)
+// called directly, so BenchmarkPooledBufferCopy can measure it against
+// platformCopy's fast path on every platform this runs on, not just the
+// ones where copy_other.go is actually the one compiled in.
+func pooledBufferCopy(dst, src *net.TCPConn) (int64, error) {
+	bufp := copyBufPool.Get().(*[]byte)
+	defer copyBufPool.Put(bufp)
+	return io.CopyBuffer(poolOnlyWriter{dst}, poolOnlyReader{src}, *bufp)
+}
+
+func benchmarkCopy(b *testing.B, copyFn func(dst, src *net.TCPConn) (int64, error)) {
+	const payloadSize = 256 * 1024
+	payload := bytes.Repeat([]byte{0x42}, payloadSize)
+
+	b.SetBytes(payloadSize)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		src, srcPeer := tcpPipe(b)
+		dst, dstPeer := tcpPipe(b)
+
+		go func() {
+			srcPeer.Write(payload)
+			srcPeer.Close()
+		}()
+		drained := make(chan struct{})
+		go func() {
+			io.Copy(io.Discard, dstPeer)
+			close(drained)
+		}()
+
+		if _, err := copyFn(dst, src); err != nil {
+			b.Fatalf("copy failed: %v", err)
+		}
+		dst.Close()
+		src.Close()
+		<-drained
+		dstPeer.Close()
+	}
+}
+
+// BenchmarkPlatformCopy measures this platform's fast path: splice(2) on
+// Linux, pooled-buffer io.CopyBuffer everywhere else (see copy_linux.go,
+// copy_other.go). Compare against BenchmarkPooledBufferCopy to see the win
+// the fast path buys at high fan-out (run with -cpuprofile on the rtmplb
+// binary itself to see it under real connection load).
+func BenchmarkPlatformCopy(b *testing.B) {
+	benchmarkCopy(b, platformCopy)
+}
+
+// BenchmarkPooledBufferCopy measures the portable pooled-buffer fallback
+// directly, regardless of which platformCopy this build actually compiled
+// in, so it's always a meaningful baseline for BenchmarkPlatformCopy.
+func BenchmarkPooledBufferCopy(b *testing.B) {
+	benchmarkCopy(b, pooledBufferCopy)
+}
@@ -0,0 +1,147 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2016 winlin
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// LogConfig selects the structured-logging encoder and the initial level.
+// The level can be changed at runtime via SIGHUP or the admin API (see
+// (*proxy).SetLogLevel); the encoder takes effect only at startup.
+type LogConfig struct {
+	// Level is one of zap's level names (debug, info, warn, error), default info.
+	Level string `json:"level,omitempty"`
+	// Encoding is "json" or "console", default console.
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// newZapLogger builds a zap.Logger from cfg plus the atomic level that backs
+// it, so callers can change verbosity at runtime without rebuilding the
+// logger or losing any sinks already wired up.
+func newZapLogger(cfg LogConfig) (*zap.Logger, *zap.AtomicLevel, error) {
+	level := zap.NewAtomicLevel()
+	if cfg.Level != "" {
+		if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+			return nil, nil, fmt.Errorf("invalid log level %v, err is %v", cfg.Level, err)
+		}
+	}
+
+	encoding := cfg.Encoding
+	if encoding == "" {
+		encoding = "console"
+	}
+	if encoding != "json" && encoding != "console" {
+		return nil, nil, fmt.Errorf("invalid log encoding %v", encoding)
+	}
+
+	encoderConf := zap.NewProductionEncoderConfig()
+	encoderConf.EncodeTime = zapcore.ISO8601TimeEncoder
+	if encoding == "console" {
+		encoderConf = zap.NewDevelopmentEncoderConfig()
+	}
+
+	zc := zap.Config{
+		Level:            level,
+		Encoding:         encoding,
+		EncoderConfig:    encoderConf,
+		OutputPaths:      []string{"stderr"},
+		ErrorOutputPaths: []string{"stderr"},
+	}
+
+	logger, err := zc.Build()
+	if err != nil {
+		return nil, nil, err
+	}
+	return logger, &level, nil
+}
+
+// SetLogLevel changes the running logger's verbosity; wired up to both
+// SIGHUP and the admin API's PUT /api/v1/loglevel so operators can turn up
+// logging on a live process without a restart.
+func (v *proxy) SetLogLevel(level string) error {
+	var l zapcore.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %v, err is %v", level, err)
+	}
+	v.logLevel.SetLevel(l)
+	return nil
+}
+
+// newConnID generates a short random correlation ID for one accepted
+// connection. serveRtmp attaches it to every log line via a zap field
+// (zap.String("conn_id", connID)) rather than context, so its whole
+// lifecycle can be grepped out of the logs. Good enough entropy for log
+// correlation; this is not a security token.
+func newConnID() string {
+	var b [10]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return fmt.Sprintf("%x", os.Getpid())
+	}
+	return fmt.Sprintf("%x", b)
+}
+
+// logCopyErr logs the result of an io.Copy on a proxied connection at a
+// level matching its severity: an ordinary peer disconnect is debug noise,
+// anything else is worth a warning.
+func logCopyErr(log *zap.Logger, msg string, err error) {
+	if isPeerClosed(err) {
+		log.Debug(msg+" (peer closed)", zap.Error(err))
+		return
+	}
+	log.Warn(msg, zap.Error(err))
+}
+
+// isPeerClosed reports whether err from an io.Copy on a proxied connection
+// is just the ordinary tail of an RTMP session ending (remote FIN/RST or a
+// close on our own side mid-copy), as opposed to a real I/O failure. Normal
+// disconnects are logged at debug level; everything else is a warning.
+func isPeerClosed(err error) bool {
+	if err == nil || err == io.EOF {
+		return true
+	}
+	if errors.Is(err, net.ErrClosed) {
+		return true
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	// net.OpError wraps the syscall errors above on some platforms in a way
+	// errors.Is doesn't always see through, so also match by message.
+	msg := err.Error()
+	return strings.Contains(msg, "use of closed network connection") ||
+		strings.Contains(msg, "connection reset by peer") ||
+		strings.Contains(msg, "broken pipe")
+}
@@ -28,9 +28,8 @@ SOFTWARE.
 package main
 
 import (
-	"bytes"
+	"bufio"
 	"context"
-	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -39,12 +38,15 @@ import (
 	oj "github.com/ossrs/go-oryx-lib/json"
 	ol "github.com/ossrs/go-oryx-lib/logger"
 	oo "github.com/ossrs/go-oryx-lib/options"
+	"go.uber.org/zap"
 	"io"
 	"net"
 	"os"
 	"os/signal"
+	"runtime/pprof"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
@@ -55,15 +57,27 @@ var signature = fmt.Sprintf("RTMPLB/%v", Version())
 type RtmpLbConfig struct {
 	Config
 	Rtmp struct {
-		Listen       string   `json:"listen"`
-		Backend      []string `json:"backend"`
-		UseRtmpProxy bool     `json:"proxy"`
+		Listen         string         `json:"listen"`
+		Backend        []string       `json:"backend"`
+		UseRtmpProxy   bool           `json:"proxy"`
+		ProxyMode      string         `json:"proxy_mode,omitempty"`
+		TrustedProxies []string       `json:"trusted_proxies,omitempty"`
+		Routes         []RouteRule    `json:"routes,omitempty"`
+		Balancer       string         `json:"balancer,omitempty"`
+		Weights        map[string]int `json:"weights,omitempty"`
 	} `json:"rtmp"`
+	Api struct {
+		Listen        string `json:"listen,omitempty"`
+		ProbeInterval string `json:"probe_interval,omitempty"`
+		ShutdownGrace string `json:"shutdown_grace,omitempty"`
+	} `json:"api,omitempty"`
+	Log LogConfig `json:"log,omitempty"`
 }
 
 func (v *RtmpLbConfig) String() string {
-	return fmt.Sprintf("%v, listen=%v, backend=%v, proxy=%v",
-		&v.Config, v.Rtmp.Listen, v.Rtmp.Backend, v.Rtmp.UseRtmpProxy)
+	return fmt.Sprintf("%v, listen=%v, backend=%v, proxy=%v, proxyMode=%v",
+		&v.Config, v.Rtmp.Listen, v.Rtmp.Backend, v.Rtmp.UseRtmpProxy,
+		normalizeProxyMode(v.Rtmp.ProxyMode, v.Rtmp.UseRtmpProxy))
 }
 
 func (v *RtmpLbConfig) Loads(c string) (err error) {
@@ -101,17 +115,181 @@ func (v *RtmpLbConfig) Loads(c string) (err error) {
 		}
 	}
 
+	switch mode := normalizeProxyMode(v.Rtmp.ProxyMode, v.Rtmp.UseRtmpProxy); mode {
+	case ProxyModeOryx, ProxyModePP1, ProxyModePP2, ProxyModeNone:
+	default:
+		return fmt.Errorf("invalid proxy_mode %v", mode)
+	}
+	if _, err = newTrustedProxies(v.Rtmp.TrustedProxies); err != nil {
+		return
+	}
+
+	switch strings.ToLower(v.Rtmp.Balancer) {
+	case "", BalancerRoundRobin, BalancerLeastConn, BalancerConsistentHash, BalancerWeighted:
+	default:
+		return fmt.Errorf("invalid balancer %v", v.Rtmp.Balancer)
+	}
+	if v.Api.ProbeInterval != "" {
+		if _, err = time.ParseDuration(v.Api.ProbeInterval); err != nil {
+			return fmt.Errorf("invalid probe_interval %v, err is %v", v.Api.ProbeInterval, err)
+		}
+	}
+	if v.Api.ShutdownGrace != "" {
+		if _, err = time.ParseDuration(v.Api.ShutdownGrace); err != nil {
+			return fmt.Errorf("invalid shutdown_grace %v, err is %v", v.Api.ShutdownGrace, err)
+		}
+	}
+	if _, _, err = newZapLogger(v.Log); err != nil {
+		return
+	}
+
 	return
 }
 
+// ProbeInterval returns the configured backend health-check interval, or a
+// sane default when unset.
+func (v *RtmpLbConfig) ProbeInterval() time.Duration {
+	if v.Api.ProbeInterval == "" {
+		return 5 * time.Second
+	}
+	d, _ := time.ParseDuration(v.Api.ProbeInterval)
+	return d
+}
+
+// ShutdownGrace returns how long a graceful shutdown waits for in-flight
+// serveRtmp goroutines to drain before forcing the remaining connections
+// closed, or a sane default when unset.
+func (v *RtmpLbConfig) ShutdownGrace() time.Duration {
+	if v.Api.ShutdownGrace == "" {
+		return 30 * time.Second
+	}
+	d, _ := time.ParseDuration(v.Api.ShutdownGrace)
+	return d
+}
+
 // The tcp porxy for rtmp backend.
+//
+// confPtr, routerPtr and balancerPtr are swapped atomically by Reload so a
+// SIGHUP hot-reload of rtmplb.json applies to new connections, reading them
+// on the hot path takes no lock, and streams already in flight are undisturbed.
 type proxy struct {
-	conf    *RtmpLbConfig
-	lbIndex uint
+	confPtr     atomic.Pointer[RtmpLbConfig]
+	lbIndex     uint32 // atomic, see nextRoundRobin
+	routerPtr   atomic.Pointer[router]
+	proxyMode   string
+	trusted     *trustedProxies
+	registry    *backendRegistry
+	balancerPtr atomic.Pointer[Balancer]
+	log         *zap.Logger
+	logLevel    *zap.AtomicLevel
+	draining    int32 // atomic bool, set while a graceful shutdown is in progress.
+	connWG      sync.WaitGroup
+}
+
+func NewProxy(conf *RtmpLbConfig, log *zap.Logger, logLevel *zap.AtomicLevel) (*proxy, error) {
+	trusted, err := newTrustedProxies(conf.Rtmp.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &proxy{
+		proxyMode: normalizeProxyMode(conf.Rtmp.ProxyMode, conf.Rtmp.UseRtmpProxy),
+		trusted:   trusted,
+		registry:  newBackendRegistry(allBackendAddrs(conf)),
+		log:       log,
+		logLevel:  logLevel,
+	}
+	v.confPtr.Store(conf)
+	v.routerPtr.Store(NewRouter(conf.Rtmp.Routes))
+	balancer := NewBalancer(conf.Rtmp.Balancer, conf.Rtmp.Weights)
+	v.balancerPtr.Store(&balancer)
+
+	return v, nil
+}
+
+func (v *proxy) Conf() *RtmpLbConfig { return v.confPtr.Load() }
+func (v *proxy) Router() *router     { return v.routerPtr.Load() }
+func (v *proxy) Balancer() Balancer  { return *v.balancerPtr.Load() }
+func (v *proxy) IsDraining() bool    { return atomic.LoadInt32(&v.draining) != 0 }
+func (v *proxy) SetDraining(draining bool) {
+	n := int32(0)
+	if draining {
+		n = 1
+	}
+	atomic.StoreInt32(&v.draining, n)
+}
+
+// Reload swaps in the backend list, balancer weights, routing rules and log
+// level from newConf without affecting connections already proxying: the
+// backend registry is diffed in place, and the router, balancer and config
+// itself are each rebuilt off to the side and published with a single
+// atomic store, so a connection already past that read sees the old value
+// to completion and every connection accepted afterwards sees the new one.
+func (v *proxy) Reload(newConf *RtmpLbConfig) error {
+	level := newConf.Log.Level
+	if level == "" {
+		level = "info"
+	}
+	if err := v.SetLogLevel(level); err != nil {
+		return err
+	}
+
+	addrs := allBackendAddrs(newConf)
+	want := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		want[addr] = true
+		v.registry.Add(addr)
+	}
+	for _, b := range v.registry.All() {
+		if !want[b.addr] {
+			v.registry.Remove(b.addr)
+		}
+	}
+
+	balancer := NewBalancer(newConf.Rtmp.Balancer, newConf.Rtmp.Weights)
+	v.balancerPtr.Store(&balancer)
+	v.routerPtr.Store(NewRouter(newConf.Rtmp.Routes))
+	v.confPtr.Store(newConf)
+
+	return nil
 }
 
-func NewProxy(conf *RtmpLbConfig) *proxy {
-	return &proxy{conf: conf}
+// allBackendAddrs returns every backend address configured anywhere in
+// conf: the default Rtmp.Backend pool plus every address reachable only
+// through a RouteRule. NewProxy and Reload both register this full set
+// with the backend registry, so routed-only backends get prober health
+// checks, drain enforcement and admin/metrics visibility exactly like the
+// default pool, instead of silently bypassing all three.
+func allBackendAddrs(conf *RtmpLbConfig) []string {
+	addrs := append([]string{}, conf.Rtmp.Backend...)
+	for _, r := range conf.Rtmp.Routes {
+		addrs = append(addrs, r.Backends...)
+	}
+	return addrs
+}
+
+// splitBackendAddr splits a "proto://host:port" backend entry into its
+// dial-ready proto and addr parts.
+func splitBackendAddr(backend string) (proto, addr string) {
+	parts := strings.SplitN(backend, "://", 2)
+	if len(parts) != 2 {
+		return "", ""
+	}
+	return parts[0], parts[1]
+}
+
+// nextRoundRobin picks the next backend out of pool, advancing the shared
+// round-robin index. Used as the selector for routes that do not request
+// consistent-hash; the default (unrouted) pool goes through v.Balancer().
+// lbIndex is shared by every serveRtmp goroutine, so it advances via
+// atomic.AddUint32 rather than a plain read-modify-write, the same pattern
+// roundRobinBalancer uses.
+func (v *proxy) nextRoundRobin(pool []string) string {
+	if len(pool) == 0 {
+		return ""
+	}
+	i := atomic.AddUint32(&v.lbIndex, 1) - 1
+	return pool[i%uint32(len(pool))]
 }
 
 const (
@@ -122,20 +300,64 @@ const (
 )
 
 func (v *proxy) serveRtmp(ctx context.Context, client *net.TCPConn) (err error) {
+	v.connWG.Add(1)
+	defer v.connWG.Done()
+
+	start := time.Now()
+	connID := newConnID()
+	log := v.log.With(zap.String("conn_id", connID), zap.Stringer("client_ip", client.RemoteAddr()))
+
 	defer func() {
 		if r := recover(); r != nil {
 			if err == nil {
 				err = fmt.Errorf("panic %v", r)
-				ol.W(ctx, "ignore panic, err is", err)
-			} else {
-				ol.W(ctx, fmt.Sprintf("ignore panic %v, err is %v", r, err))
 			}
+			log.Warn("ignore panic", zap.Any("panic", r), zap.Error(err))
 		}
 	}()
 	defer client.Close()
 
-	// connect to backend.
+	// if the immediate peer is a trusted upstream L4 balancer, consume the
+	// PROXY protocol header it prepended and use the real client address it
+	// carries for logs and for the header we write to our own backend.
+	cc := &clientConn{TCPConn: client, r: bufio.NewReader(client)}
+	realClient := client.RemoteAddr()
+	if v.trusted != nil && v.trusted.Trusts(client.RemoteAddr()) {
+		if addr, perr := readProxyHeader(cc.r); perr != nil {
+			log.Warn("read proxy header from trusted peer failed", zap.Error(perr))
+		} else {
+			realClient = addr
+			log = log.With(zap.Stringer("client_ip", realClient))
+		}
+	}
+
+	// peek the handshake and connect command, if routing rules are configured
+	// or the default balancer needs a stream key to hash on.
+	conf := v.Conf()
+	var peeker rtmpPeeker
+	var app, stream, streamKey string
+	routed := false
+	needPeek := len(conf.Rtmp.Routes) > 0 || strings.EqualFold(conf.Rtmp.Balancer, BalancerConsistentHash)
+	if needPeek {
+		if a, s, perr := peeker.Peek(cc); perr != nil {
+			log.Debug("peek rtmp handshake failed, falling back to the default balancer", zap.Error(perr))
+		} else {
+			app, stream = a, s
+			streamKey = stream
+			if streamKey == "" {
+				streamKey = app
+			}
+			routed = true
+		}
+	}
+
+	// connect to backend. A routed pick is re-resolved on every retry (not
+	// just computed once up front) so a rule's pool fails over to another of
+	// its own backends the same way the unrouted path already does via
+	// v.Balancer().Pick below, instead of blindly redialing one unreachable
+	// routed address for the whole RetryMax budget.
 	var backend *net.TCPConn
+	var picked string
 	connectBackend := func() error {
 		defer func() {
 			if backend == nil {
@@ -143,19 +365,27 @@ func (v *proxy) serveRtmp(ctx context.Context, client *net.TCPConn) (err error)
 			}
 		}()
 
-		var proto, addr string
-		if backend := v.conf.Rtmp.Backend[v.lbIndex]; backend != "" {
-			v.lbIndex = (v.lbIndex + 1) % uint(len(v.conf.Rtmp.Backend))
-			addrs := strings.Split(backend, "://")
-			proto, addr = addrs[0], addrs[1]
+		picked = ""
+		if routed {
+			if b, matched := v.Router().Resolve(app, stream, v.registry, v.nextRoundRobin); matched {
+				picked = b
+				log.Debug("routed", zap.String("app", app), zap.String("stream", stream), zap.String("backend", picked))
+			}
+		}
+		if picked == "" {
+			picked = v.Balancer().Pick(v.registry, streamKey)
 		}
 
-		if c, err := net.DialTimeout(proto, addr, RetryBackend); err != nil {
-			ol.W(ctx, "connect backend", addr, "failed, err is", err)
+		proto, addr := splitBackendAddr(picked)
+		c, err := net.DialTimeout(proto, addr, RetryBackend)
+		if err != nil {
+			log.Warn("connect backend failed", zap.String("backend", addr), zap.Error(err))
+			if stats := v.registry.Get(picked); stats != nil {
+				atomic.AddInt64(&stats.reconnects, 1)
+			}
 			return err
-		} else {
-			backend = c.(*net.TCPConn)
 		}
+		backend = c.(*net.TCPConn)
 
 		return nil
 	}
@@ -165,28 +395,77 @@ func (v *proxy) serveRtmp(ctx context.Context, client *net.TCPConn) (err error)
 		}
 	}
 	if backend == nil {
-		ol.W(ctx, "proxy failed for no backend, err is", err)
+		log.Warn("proxy failed for no backend", zap.Error(err))
 		return
 	}
 	defer backend.Close()
-	ol.T(ctx, fmt.Sprintf("proxy %v to %v, useProxyProtocol=%v",
-		client.RemoteAddr(), backend.RemoteAddr(), v.conf.Rtmp.UseRtmpProxy))
+	log = log.With(zap.String("backend", picked))
+	log.Debug("proxy starting", zap.String("proxy_mode", v.proxyMode))
+
+	// track this stream against the backend it landed on, for LeastConn and
+	// for the admin API / metrics.
+	if stats := v.registry.Get(picked); stats != nil {
+		atomic.AddInt64(&stats.conns, 1)
+		defer atomic.AddInt64(&stats.conns, -1)
+	}
+
+	// Drain whatever bufio already pulled off the wire beyond what the proxy
+	// header parse / rtmp peek actually consumed, so nothing is lost once we
+	// stop reading through cc and start reading the raw client conn below.
+	if n := cc.r.Buffered(); n > 0 {
+		extra, _ := cc.r.Peek(n)
+		peeker.Buffered.Write(extra)
+	}
+
+	// Write the configured proxy header, and everything peeked off the
+	// client, up front and once, before either copy goroutine starts. Doing
+	// it here instead of racing it inside the egress copy leaves that copy
+	// as a plain platformCopy(backend, client) with no wrapping reader,
+	// which on Linux is what lets TCPConn.ReadFrom's splice(2) fast path
+	// kick in instead of falling back to a userspace buffer.
+	realTCPAddr, _ := realClient.(*net.TCPAddr)
+	backendLocalAddr, _ := backend.LocalAddr().(*net.TCPAddr)
+	if err = writeProxyHeader(backend, v.proxyMode, realTCPAddr, backendLocalAddr, streamKey); err != nil {
+		log.Error("write proxy header failed", zap.String("proxy_mode", v.proxyMode), zap.Error(err))
+		return
+	}
+	// If Peek answered the client's handshake itself, the backend never saw
+	// a handshake at all: do an independent one of our own against it now,
+	// rather than forwarding the client's raw handshake bytes a second
+	// time, which the backend would mistake for a brand-new connection and
+	// answer with its own unsolicited S0/S1/S2 spliced into the stream.
+	if peeker.HandshakeAnswered {
+		if err = ClientHandshake(backend); err != nil {
+			log.Error("backend handshake failed", zap.Error(err))
+			return
+		}
+	}
+	if peeker.Buffered.Len() > 0 {
+		if _, err = backend.Write(peeker.Buffered.Bytes()); err != nil {
+			log.Error("replay peeked bytes failed", zap.Error(err))
+			return
+		}
+	}
 
-	// proxy c to conn
 	var wg sync.WaitGroup
 
 	var nr, nw int64
 	defer func() {
-		ol.T(ctx, fmt.Sprintf("proxy client ok, read=%v, write=%v", nr, nw))
+		log.Info("proxy closed",
+			zap.Int64("bytes_read", nr), zap.Int64("bytes_written", nw),
+			zap.Int64("duration_ms", time.Since(start).Milliseconds()))
+		if stats := v.registry.Get(picked); stats != nil {
+			atomic.AddInt64(&stats.bytesIn, nr)
+			atomic.AddInt64(&stats.bytesOut, nw)
+		}
 	}()
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		defer client.Close()
-		if nw, err = io.Copy(client, backend); err != nil {
-			ol.E(ctx, fmt.Sprintf("proxy rtmp<=backend failed, nn=%v, err is %v", nw, err))
-			return
+		if nw, err = platformCopy(client, backend); err != nil {
+			logCopyErr(log, "proxy rtmp<=backend", err)
 		}
 	}()
 
@@ -194,31 +473,8 @@ func (v *proxy) serveRtmp(ctx context.Context, client *net.TCPConn) (err error)
 	go func() {
 		defer wg.Done()
 		defer client.Close()
-
-		// write proxy header.
-		// @see https://github.com/ossrs/go-oryx/wiki/RtmpProxy
-		if v.conf.Rtmp.UseRtmpProxy {
-			var ip []byte
-			if addr, ok := client.RemoteAddr().(*net.TCPAddr); ok {
-				// TODO: support ipv6 client.
-				ip = addr.IP.To4()
-			}
-
-			b := &bytes.Buffer{}
-			b.WriteByte(0xF3)
-			binary.Write(b, binary.BigEndian, uint16(len(ip)))
-			b.Write(ip)
-			//ol.T(ctx, "write rtmp protocol", b.Bytes())
-
-			if _, err = backend.Write(b.Bytes()); err != nil {
-				ol.E(ctx, fmt.Sprintf("write proxy failed, b=%v, err is %v", b.Bytes(), err))
-				return
-			}
-		}
-
-		if nr, err = io.Copy(backend, client); err != nil {
-			ol.E(ctx, fmt.Sprintf("proxy rtmp=>backend failed, nn=%v, err is %v", nr, err))
-			return
+		if nr, err = platformCopy(backend, client); err != nil {
+			logCopyErr(log, "proxy rtmp=>backend", err)
 		}
 	}()
 
@@ -241,13 +497,28 @@ func main() {
 	var err error
 
 	// for shell.
-	var backend, port string
+	var backend, port, cpuprofile string
 	flag.StringVar(&backend, "b", "", "The backend server tcp://host:port, optional.")
 	flag.StringVar(&port, "l", "", "The listen tcp://host:port, optional.")
+	flag.StringVar(&cpuprofile, "cpuprofile", "", "Write a pprof CPU profile to this file, optional; useful for comparing the proxy loop's splice fast path against the buffered fallback under load.")
 
 	confFile := oo.ParseArgv("../conf/rtmplb.json", Version(), signature)
 	fmt.Println("RTMPLB is the load-balance for RTMP streaming, config is", confFile)
 
+	if cpuprofile != "" {
+		f, err := os.Create(cpuprofile)
+		if err != nil {
+			ol.E(nil, "create cpuprofile failed, err is", err)
+			return
+		}
+		defer f.Close()
+		if err := pprof.StartCPUProfile(f); err != nil {
+			ol.E(nil, "start cpuprofile failed, err is", err)
+			return
+		}
+		defer pprof.StopCPUProfile()
+	}
+
 	conf := &RtmpLbConfig{}
 	if err = conf.Loads(confFile); err != nil {
 		ol.E(nil, "Loads config failed, err is", err)
@@ -263,33 +534,111 @@ func main() {
 		conf.Rtmp.Backend = append(conf.Rtmp.Backend, backend)
 	}
 
+	log, logLevel, err := newZapLogger(conf.Log)
+	if err != nil {
+		ol.E(nil, "build logger failed, err is", err)
+		return
+	}
+	defer log.Sync()
+
 	ctx, cancel := context.WithCancel(context.Background())
-	ol.T(ctx, fmt.Sprintf("Config ok, %v", conf))
+	log.Info("config ok", zap.Stringer("config", conf))
 
 	var listener *TcpListeners
 	if listener, err = NewTcpListeners([]string{conf.Rtmp.Listen}); err != nil {
-		ol.E(ctx, "create listener failed, err is", err)
+		log.Error("create listener failed", zap.Error(err))
 		return
 	}
 	defer listener.Close()
 
 	if err = listener.ListenTCP(ctx); err != nil {
-		ol.E(ctx, "listen tcp failed, err is", err)
+		log.Error("listen tcp failed", zap.Error(err))
 		return
 	}
 
-	proxy := NewProxy(conf)
+	proxy, err := NewProxy(conf, log, logLevel)
+	if err != nil {
+		log.Error("create proxy failed", zap.Error(err))
+		return
+	}
 	oh.Server = signature
 
+	prober := NewProber(proxy.registry, conf.ProbeInterval(), log)
+	go prober.Run(ctx)
+
+	var admin *adminServer
+	if conf.Api.Listen != "" {
+		admin = NewAdminServer(conf.Api.Listen, proxy.registry, proxy.SetLogLevel, proxy.IsDraining, log)
+		go func() {
+			if err := admin.ListenAndServe(ctx); err != nil {
+				log.Error("admin api serve failed", zap.Error(err))
+			}
+		}()
+		go func() {
+			<-ctx.Done()
+			admin.Close()
+		}()
+	}
+
+	// SIGINT/SIGTERM starts a two-phase shutdown: stop accepting new
+	// connections and publish the draining state to the admin API, wait up
+	// to shutdown_grace for every in-flight serveRtmp goroutine to finish on
+	// its own, then cancel ctx to force-close whatever is left. shuttingDown
+	// and shutdownDone let the accept loop below block until this sequence
+	// actually finishes, instead of main() returning (and killing every
+	// in-flight proxied connection) the instant listener.Close() below
+	// makes AcceptTCP start erroring out.
+	var shuttingDown int32 // atomic bool
+	shutdownDone := make(chan struct{})
 	go func() {
-		c := make(chan os.Signal, 1)
-		signal.Notify(c, syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL)
+		defer close(shutdownDone)
 
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
 		<-c
+
+		log.Info("shutdown requested, draining", zap.Duration("grace", conf.ShutdownGrace()))
+		proxy.SetDraining(true)
+		atomic.StoreInt32(&shuttingDown, 1)
+		listener.Close()
+
+		drained := make(chan struct{})
+		go func() {
+			proxy.connWG.Wait()
+			close(drained)
+		}()
+
+		select {
+		case <-drained:
+			log.Info("drain complete")
+		case <-time.After(conf.ShutdownGrace()):
+			log.Warn("drain grace period expired, forcing remaining connections closed")
+		}
 		cancel()
 	}()
 
-	defer ol.T(ctx, "serve ok")
+	// SIGHUP hot-reloads rtmplb.json: backend list, balancer weights, routing
+	// rules and log level apply to new connections without dropping any
+	// stream already in flight.
+	go func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, syscall.SIGHUP)
+
+		for range c {
+			reloaded := &RtmpLbConfig{}
+			if err := reloaded.Loads(confFile); err != nil {
+				log.Warn("SIGHUP reload config failed", zap.Error(err))
+				continue
+			}
+			if err := proxy.Reload(reloaded); err != nil {
+				log.Warn("SIGHUP apply config failed", zap.Error(err))
+				continue
+			}
+			log.Info("SIGHUP reloaded config", zap.Stringer("config", reloaded))
+		}
+	}()
+
+	defer log.Info("serve ok")
 
 	// rtmp connections
 	go func() {
@@ -297,21 +646,28 @@ func main() {
 		listener.Close()
 	}()
 
-	ol.T(ctx, "rtmp accepter ready")
-	defer ol.T(ctx, "rtmp accepter ok")
+	log.Info("rtmp accepter ready")
+	defer log.Info("rtmp accepter ok")
 
 	for {
 		var c *net.TCPConn
 		if c, err = listener.AcceptTCP(); err != nil {
 			if err != io.EOF {
-				ol.E(ctx, "accept failed, err is", err)
+				log.Error("accept failed", zap.Error(err))
 			}
 			break
 		}
 
-		//ol.T(ctx, "got rtmp client", c.RemoteAddr())
 		go proxy.serveRtmp(ctx, c)
 	}
 
+	// If a signal put us here, wait for the drain/grace-period sequence
+	// above to actually finish before returning: returning from main()
+	// ends the process immediately, which would otherwise cut every
+	// in-flight proxied connection off mid-copy regardless of draining.
+	if atomic.LoadInt32(&shuttingDown) != 0 {
+		<-shutdownDone
+	}
+
 	return
 }
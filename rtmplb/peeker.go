@@ -0,0 +1,248 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2016 winlin
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// rtmpPeeker terminates just enough of the RTMP handshake and chunk stream
+// to learn which app/stream the client is publishing or playing, so the
+// proxy can route on it the same way inetaf/tcpproxy's tlsrouter peeks a
+// TLS ClientHello for SNI. The handshake itself (C0/C1/C2/S0/S1/S2) is never
+// replayed anywhere: it is a private conversation between Peek and the
+// client that the backend was never part of. Only the bytes read after the
+// handshake completes — the chunk-0 "connect" command — are kept in
+// Buffered, so serveRtmp can replay just that to whichever backend it
+// dials, once that backend has gone through its own independent handshake
+// via ClientHandshake.
+type rtmpPeeker struct {
+	Buffered bytes.Buffer
+
+	// HandshakeAnswered is set as soon as Peek has written S0/S1/S2 to the
+	// client, even if Peek goes on to fail reading C2 or the connect
+	// command. The client's real handshake is already spent at that point,
+	// so serveRtmp must open an independent one against the backend via
+	// ClientHandshake rather than ever forwarding the client's raw
+	// handshake bytes to it.
+	HandshakeAnswered bool
+}
+
+const (
+	rtmpHandshakeSize = 1536
+	rtmpVersion       = 0x03
+)
+
+// Peek drives a minimal RTMP handshake against conn (acting as the server
+// side: C0/C1 in, S0/S1/S2 out, C2 in), then reads chunk 0 messages until it
+// sees the "connect" command and extracts the app name from its tcUrl.
+func (v *rtmpPeeker) Peek(conn io.ReadWriter) (app, stream string, err error) {
+	c0c1 := make([]byte, 1+rtmpHandshakeSize)
+	if _, err = io.ReadFull(conn, c0c1); err != nil {
+		return "", "", fmt.Errorf("read c0c1 failed, err is %v", err)
+	}
+	if c0c1[0] != rtmpVersion {
+		return "", "", fmt.Errorf("unsupported rtmp version %v", c0c1[0])
+	}
+
+	// S0+S1+S2: echo the client's C1 back as both S1 and S2.
+	s0s1s2 := make([]byte, 1+rtmpHandshakeSize+rtmpHandshakeSize)
+	s0s1s2[0] = rtmpVersion
+	copy(s0s1s2[1:1+rtmpHandshakeSize], c0c1[1:])
+	copy(s0s1s2[1+rtmpHandshakeSize:], c0c1[1:])
+	if _, err = conn.Write(s0s1s2); err != nil {
+		return "", "", fmt.Errorf("write s0s1s2 failed, err is %v", err)
+	}
+	v.HandshakeAnswered = true
+
+	c2 := make([]byte, rtmpHandshakeSize)
+	if _, err = io.ReadFull(conn, c2); err != nil {
+		return "", "", fmt.Errorf("read c2 failed, err is %v", err)
+	}
+
+	tcUrl, err := v.readConnectTcUrl(conn)
+	if err != nil {
+		return "", "", err
+	}
+
+	app, stream = parseTcUrl(tcUrl)
+	return app, stream, nil
+}
+
+// ClientHandshake performs the client side of the RTMP handshake (C0/C1
+// out, S0/S1/S2 in, C2 out) against conn. serveRtmp calls this against the
+// backend whenever Peek has already answered the real client's handshake
+// itself: the backend never saw that handshake, and forwarding the
+// client's raw C0/C1/C2 to it would make the backend perform yet another
+// server-side handshake and splice a second, unsolicited S0/S1/S2 (and
+// later its connect reply) into the stream the client believes is already
+// established. Doing our own, independent handshake here keeps the
+// backend's session bookkeeping consistent with what it actually sent.
+func ClientHandshake(conn io.ReadWriter) error {
+	c0c1 := make([]byte, 1+rtmpHandshakeSize)
+	c0c1[0] = rtmpVersion
+	if _, err := conn.Write(c0c1); err != nil {
+		return fmt.Errorf("write c0c1 failed, err is %v", err)
+	}
+
+	s0s1s2 := make([]byte, 1+rtmpHandshakeSize+rtmpHandshakeSize)
+	if _, err := io.ReadFull(conn, s0s1s2); err != nil {
+		return fmt.Errorf("read s0s1s2 failed, err is %v", err)
+	}
+	if s0s1s2[0] != rtmpVersion {
+		return fmt.Errorf("unsupported rtmp version %v", s0s1s2[0])
+	}
+
+	// C2 echoes the server's S1 back.
+	c2 := s0s1s2[1 : 1+rtmpHandshakeSize]
+	if _, err := conn.Write(c2); err != nil {
+		return fmt.Errorf("write c2 failed, err is %v", err)
+	}
+	return nil
+}
+
+// readConnectTcUrl reads chunk-0 messages until it finds the AMF0-encoded
+// "connect" command and returns the tcUrl property out of its command
+// object. Everything it reads is teed into Buffered: this is the one part
+// of Peek's conversation with the client that the backend must also see,
+// since it's the connect command the backend needs to start the session.
+func (v *rtmpPeeker) readConnectTcUrl(conn io.Reader) (string, error) {
+	r := io.TeeReader(conn, &v.Buffered)
+
+	for {
+		fmtAndCsid, err := readByte(r)
+		if err != nil {
+			return "", fmt.Errorf("read chunk basic header failed, err is %v", err)
+		}
+		chunkFmt := fmtAndCsid >> 6
+
+		var headerLen int
+		switch chunkFmt {
+		case 0:
+			headerLen = 11
+		case 1:
+			headerLen = 7
+		case 2:
+			headerLen = 3
+		default:
+			headerLen = 0
+		}
+
+		header := make([]byte, headerLen)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return "", fmt.Errorf("read chunk message header failed, err is %v", err)
+		}
+		if chunkFmt != 0 || headerLen < 11 {
+			// Only type-0 chunks carry an explicit length; anything else on
+			// the very first chunk of a connection is not a connect command.
+			continue
+		}
+
+		length := int(header[5]) | int(header[4])<<8 | int(header[3])<<16
+		typeId := header[6]
+		if length <= 0 || length > 4096 {
+			return "", fmt.Errorf("invalid connect command length %v", length)
+		}
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return "", fmt.Errorf("read connect command failed, err is %v", err)
+		}
+
+		const amf0CommandMessage = 20
+		if typeId != amf0CommandMessage {
+			continue
+		}
+
+		name, _ := amf0ReadString(payload)
+		if name != "connect" {
+			continue
+		}
+
+		return amf0FindStringProp(payload, "tcUrl")
+	}
+}
+
+func readByte(r io.Reader) (byte, error) {
+	var b [1]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// amf0ReadString reads a single AMF0 string value (0x02 marker, u16 length,
+// utf8 bytes) from the front of b, returning the remainder.
+func amf0ReadString(b []byte) (string, []byte) {
+	if len(b) < 3 || b[0] != 0x02 {
+		return "", b
+	}
+	n := int(binary.BigEndian.Uint16(b[1:3]))
+	if len(b) < 3+n {
+		return "", nil
+	}
+	return string(b[3 : 3+n]), b[3+n:]
+}
+
+// amf0FindStringProp does a best-effort scan of an AMF0 object for a string
+// property with the given name; good enough to pull tcUrl out of a connect
+// command without a full AMF0 decoder.
+func amf0FindStringProp(b []byte, name string) (string, error) {
+	needle := []byte(name)
+	for i := 0; i+2 < len(b); i++ {
+		if !bytes.HasPrefix(b[i:], needle) {
+			continue
+		}
+		rest := b[i+len(needle):]
+		if s, _ := amf0ReadString(rest); s != "" {
+			return s, nil
+		}
+	}
+	return "", fmt.Errorf("%v not found in connect command", name)
+}
+
+// parseTcUrl extracts the app name (and, when present, a trailing stream
+// name) out of an RTMP tcUrl such as rtmp://host/app or rtmp://host/app/stream.
+func parseTcUrl(tcUrl string) (app, stream string) {
+	u, err := url.Parse(tcUrl)
+	if err != nil {
+		return "", ""
+	}
+
+	path := strings.TrimPrefix(u.Path, "/")
+	parts := strings.SplitN(path, "/", 2)
+	if len(parts) > 0 {
+		app = parts[0]
+	}
+	if len(parts) > 1 {
+		stream = parts[1]
+	}
+	return app, stream
+}
@@ -0,0 +1,211 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2016 winlin
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseTcUrl(t *testing.T) {
+	cases := []struct {
+		tcUrl      string
+		app, strea string
+	}{
+		{"rtmp://host/live", "live", ""},
+		{"rtmp://host/live/stream1", "live", "stream1"},
+		{"not a url %%", "", ""},
+	}
+	for _, c := range cases {
+		app, stream := parseTcUrl(c.tcUrl)
+		if app != c.app || stream != c.strea {
+			t.Errorf("parseTcUrl(%q) = (%q, %q), want (%q, %q)", c.tcUrl, app, stream, c.app, c.strea)
+		}
+	}
+}
+
+func TestAmf0ReadString(t *testing.T) {
+	b := append([]byte{0x02, 0x00, 0x05}, []byte("hello")...)
+	s, rest := amf0ReadString(b)
+	if s != "hello" || len(rest) != 0 {
+		t.Fatalf("got (%q, %v), want (\"hello\", empty)", s, rest)
+	}
+
+	if s, _ := amf0ReadString([]byte{0x00}); s != "" {
+		t.Fatalf("non-string marker should return empty, got %q", s)
+	}
+}
+
+func TestAmf0FindStringProp(t *testing.T) {
+	payload := amf0PropBytes("tcUrl", "rtmp://host/live")
+	got, err := amf0FindStringProp(payload, "tcUrl")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "rtmp://host/live" {
+		t.Fatalf("got %q, want rtmp://host/live", got)
+	}
+
+	if _, err := amf0FindStringProp(payload, "missing"); err == nil {
+		t.Fatalf("expected an error for a property that isn't present")
+	}
+}
+
+// amf0PropBytes builds a minimal AMF0 property name/value pair, as it would
+// appear inside a connect command's argument object: a u16-length-prefixed
+// property name directly followed by an AMF0 string value.
+func amf0PropBytes(name, value string) []byte {
+	var b []byte
+	b = append(b, byte(len(name)>>8), byte(len(name)))
+	b = append(b, []byte(name)...)
+	b = append(b, 0x02, byte(len(value)>>8), byte(len(value)))
+	b = append(b, []byte(value)...)
+	return b
+}
+
+// buildConnectChunk encodes a minimal chunk-0 AMF0 command message carrying
+// a "connect" command whose tcUrl argument is tcUrl, matching exactly what
+// rtmpPeeker.readConnectTcUrl knows how to parse.
+func buildConnectChunk(tcUrl string) []byte {
+	var payload []byte
+	payload = append(payload, 0x02, 0x00, 0x07)
+	payload = append(payload, []byte("connect")...)
+	payload = append(payload, amf0PropBytes("tcUrl", tcUrl)...)
+
+	const amf0CommandMessage = 20
+	header := make([]byte, 11)
+	header[3] = byte(len(payload) >> 16)
+	header[4] = byte(len(payload) >> 8)
+	header[5] = byte(len(payload))
+	header[6] = amf0CommandMessage
+
+	chunk := []byte{0x03} // fmt=0, csid=3
+	chunk = append(chunk, header...)
+	chunk = append(chunk, payload...)
+	return chunk
+}
+
+func TestPeekReturnsAppAndStreamFromConnect(t *testing.T) {
+	clientSide, serverSide := net.Pipe()
+	defer clientSide.Close()
+	defer serverSide.Close()
+
+	var peeker rtmpPeeker
+	type result struct {
+		app, stream string
+		err         error
+	}
+	done := make(chan result, 1)
+	go func() {
+		app, stream, err := peeker.Peek(serverSide)
+		done <- result{app, stream, err}
+	}()
+
+	driveClientHandshake(t, clientSide)
+	if _, err := clientSide.Write(buildConnectChunk("rtmp://host/live/stream1")); err != nil {
+		t.Fatalf("write connect chunk failed: %v", err)
+	}
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			t.Fatalf("Peek failed: %v", r.err)
+		}
+		if r.app != "live" || r.stream != "stream1" {
+			t.Fatalf("got app=%q stream=%q, want app=live stream=stream1", r.app, r.stream)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Peek did not return in time")
+	}
+
+	if peeker.Buffered.Len() == 0 {
+		t.Fatalf("expected the connect chunk to be kept in Buffered for replay")
+	}
+}
+
+// driveClientHandshake plays the client side of the RTMP handshake against
+// conn: write C0/C1, read back S0/S1/S2, write C2. Mirrors what a real RTMP
+// client does against rtmpPeeker.Peek acting as the server.
+func driveClientHandshake(t *testing.T, conn net.Conn) {
+	t.Helper()
+
+	c0c1 := make([]byte, 1+rtmpHandshakeSize)
+	c0c1[0] = rtmpVersion
+	if _, err := conn.Write(c0c1); err != nil {
+		t.Fatalf("write c0c1 failed: %v", err)
+	}
+
+	s0s1s2 := make([]byte, 1+rtmpHandshakeSize+rtmpHandshakeSize)
+	if _, err := io.ReadFull(conn, s0s1s2); err != nil {
+		t.Fatalf("read s0s1s2 failed: %v", err)
+	}
+
+	c2 := make([]byte, rtmpHandshakeSize)
+	if _, err := conn.Write(c2); err != nil {
+		t.Fatalf("write c2 failed: %v", err)
+	}
+}
+
+func TestClientHandshakeAgainstAServerPeer(t *testing.T) {
+	clientSide, serverSide := net.Pipe()
+	defer clientSide.Close()
+	defer serverSide.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- ClientHandshake(clientSide)
+	}()
+
+	// play the server side: read C0/C1, answer S0/S1/S2, read C2.
+	c0c1 := make([]byte, 1+rtmpHandshakeSize)
+	if _, err := io.ReadFull(serverSide, c0c1); err != nil {
+		t.Fatalf("read c0c1 failed: %v", err)
+	}
+	if c0c1[0] != rtmpVersion {
+		t.Fatalf("got rtmp version %v, want %v", c0c1[0], rtmpVersion)
+	}
+
+	s0s1s2 := make([]byte, 1+rtmpHandshakeSize+rtmpHandshakeSize)
+	s0s1s2[0] = rtmpVersion
+	if _, err := serverSide.Write(s0s1s2); err != nil {
+		t.Fatalf("write s0s1s2 failed: %v", err)
+	}
+
+	c2 := make([]byte, rtmpHandshakeSize)
+	if _, err := io.ReadFull(serverSide, c2); err != nil {
+		t.Fatalf("read c2 failed: %v", err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("ClientHandshake failed: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ClientHandshake did not return in time")
+	}
+}
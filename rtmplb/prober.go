@@ -0,0 +1,95 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2016 winlin
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// prober periodically TCP-dials every backend in a registry to track whether
+// it is reachable, marking it down after a failed dial so balancers skip it
+// until the next successful probe marks it back up.
+type prober struct {
+	reg      *backendRegistry
+	interval time.Duration
+	timeout  time.Duration
+	log      *zap.Logger
+}
+
+func NewProber(reg *backendRegistry, interval time.Duration, log *zap.Logger) *prober {
+	timeout := interval / 2
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	return &prober{reg: reg, interval: interval, timeout: timeout, log: log}
+}
+
+// Run blocks probing on a ticker until ctx is done.
+func (v *prober) Run(ctx context.Context) {
+	ticker := time.NewTicker(v.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			v.probeAll(ctx)
+		}
+	}
+}
+
+func (v *prober) probeAll(ctx context.Context) {
+	for _, b := range v.reg.All() {
+		go v.probeOne(ctx, b)
+	}
+}
+
+func (v *prober) probeOne(ctx context.Context, b *backendStats) {
+	proto, addr := splitBackendAddr(b.addr)
+
+	start := time.Now()
+	c, err := net.DialTimeout(proto, addr, v.timeout)
+	if err != nil {
+		if b.IsUp() {
+			v.log.Warn("probe failed, marking down", zap.String("backend", b.addr), zap.Error(err))
+		}
+		b.setUp(false)
+		atomic.AddInt64(&b.reconnects, 1)
+		return
+	}
+	defer c.Close()
+
+	if !b.IsUp() {
+		v.log.Info("probe ok, marking up", zap.String("backend", b.addr))
+	}
+	atomic.StoreInt64(&b.lastRttMs, time.Since(start).Milliseconds())
+	b.setUp(true)
+}
@@ -0,0 +1,336 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2016 winlin
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// The proxy-header mode written on the backend leg, configured by
+// Rtmp.ProxyMode. "oryx" keeps the original proprietary 0xF3-prefixed header
+// for backwards compatibility, "pp1"/"pp2" write a standard HAProxy PROXY
+// protocol header, and "none" disables the header entirely.
+const (
+	ProxyModeOryx = "oryx"
+	ProxyModePP1  = "pp1"
+	ProxyModePP2  = "pp2"
+	ProxyModeNone = "none"
+)
+
+// normalizeProxyMode applies the legacy UseRtmpProxy bool as a default when
+// ProxyMode is not set, so existing rtmplb.json configs keep working.
+func normalizeProxyMode(mode string, useRtmpProxy bool) string {
+	if mode != "" {
+		return mode
+	}
+	if useRtmpProxy {
+		return ProxyModeOryx
+	}
+	return ProxyModeNone
+}
+
+// writeProxyHeader writes the configured proxy header for client->backend to
+// w, describing a connection from src to dst. streamKey is the stream name
+// parsed from the tcUrl (or the app name when the tcUrl has no stream), if
+// known; pp2 mode carries it as a vendor TLV so a backend
+// that understands it can skip re-parsing the connect command, other modes
+// ignore it.
+func writeProxyHeader(w writerTo, mode string, src, dst *net.TCPAddr, streamKey string) error {
+	switch mode {
+	case ProxyModeOryx:
+		return writeOryxProxyHeader(w, src)
+	case ProxyModePP1:
+		return writeProxyProtocolV1(w, src, dst)
+	case ProxyModePP2:
+		return writeProxyProtocolV2(w, src, dst, streamKeyTLVs(streamKey)...)
+	case ProxyModeNone, "":
+		return nil
+	default:
+		return fmt.Errorf("unknown proxy_mode %v", mode)
+	}
+}
+
+// streamKeyTLVs returns the pp2TlvStreamKey TLV carrying key, or nil when key
+// is empty (e.g. no routing rule or balancer needed one, see serveRtmp).
+func streamKeyTLVs(key string) []proxyProtocolTLV {
+	if key == "" {
+		return nil
+	}
+	return []proxyProtocolTLV{{Type: pp2TlvStreamKey, Value: []byte(key)}}
+}
+
+// writerTo is the minimal surface serveRtmp needs; satisfied by *net.TCPConn.
+type writerTo interface {
+	Write(b []byte) (int, error)
+}
+
+// writeOryxProxyHeader writes the original go-oryx proprietary header:
+// 0xF3, a big-endian uint16 IP length, then the raw (IPv4-only) IP bytes.
+// @see https://github.com/ossrs/go-oryx/wiki/RtmpProxy
+func writeOryxProxyHeader(w writerTo, src *net.TCPAddr) error {
+	var ip []byte
+	if src != nil {
+		ip = src.IP.To4()
+	}
+
+	b := &bytes.Buffer{}
+	b.WriteByte(0xF3)
+	binary.Write(b, binary.BigEndian, uint16(len(ip)))
+	b.Write(ip)
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// writeProxyProtocolV1 writes a HAProxy PROXY protocol v1 (text) header.
+// @see https://www.haproxy.org/download/1.8/doc/proxy-protocol.txt
+func writeProxyProtocolV1(w writerTo, src, dst *net.TCPAddr) error {
+	if src == nil || dst == nil || src.IP.To4() == nil && src.IP.To16() == nil {
+		_, err := w.Write([]byte("PROXY UNKNOWN\r\n"))
+		return err
+	}
+
+	proto := "TCP4"
+	srcIP, dstIP := src.IP.To4(), dst.IP.To4()
+	if srcIP == nil {
+		proto = "TCP6"
+		srcIP, dstIP = src.IP.To16(), dst.IP.To16()
+	}
+	if dstIP == nil {
+		dstIP = srcIP
+	}
+
+	line := fmt.Sprintf("PROXY %v %v %v %v %v\r\n", proto, srcIP, dstIP, src.Port, dst.Port)
+	_, err := w.Write([]byte(line))
+	return err
+}
+
+var proxyProtocolV2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+const (
+	pp2VersionCmd = 0x21 // version 2, PROXY command.
+	pp2FamInet4   = 0x11 // AF_INET, STREAM.
+	pp2FamInet6   = 0x21 // AF_INET6, STREAM.
+
+	// TLV type carrying the stream key serveRtmp parsed from the tcUrl (the
+	// stream name, or the app name when there is none), so a backend that
+	// understands it can skip re-parsing the connect command.
+	pp2TlvStreamKey = 0xE0
+)
+
+// writeProxyProtocolV2 writes a HAProxy PROXY protocol v2 (binary) header,
+// including source/destination address and port and, when known, a
+// vendor TLV carrying the routed stream key.
+func writeProxyProtocolV2(w writerTo, src, dst *net.TCPAddr, tlvs ...proxyProtocolTLV) error {
+	b := &bytes.Buffer{}
+	b.Write(proxyProtocolV2Signature)
+	b.WriteByte(pp2VersionCmd)
+
+	var addrs []byte
+	fam := byte(pp2FamInet4)
+	if src != nil && src.IP.To4() == nil {
+		fam = pp2FamInet6
+	}
+
+	switch fam {
+	case pp2FamInet4:
+		var s, d [4]byte
+		if src != nil {
+			copy(s[:], src.IP.To4())
+		}
+		if dst != nil {
+			copy(d[:], dst.IP.To4())
+		}
+		addrs = append(addrs, s[:]...)
+		addrs = append(addrs, d[:]...)
+	case pp2FamInet6:
+		var s, d [16]byte
+		if src != nil {
+			copy(s[:], src.IP.To16())
+		}
+		if dst != nil {
+			copy(d[:], dst.IP.To16())
+		}
+		addrs = append(addrs, s[:]...)
+		addrs = append(addrs, d[:]...)
+	}
+
+	var ports [4]byte
+	if src != nil {
+		binary.BigEndian.PutUint16(ports[0:2], uint16(src.Port))
+	}
+	if dst != nil {
+		binary.BigEndian.PutUint16(ports[2:4], uint16(dst.Port))
+	}
+	addrs = append(addrs, ports[:]...)
+
+	var tlvBytes []byte
+	for _, tlv := range tlvs {
+		tlvBytes = append(tlvBytes, tlv.Type)
+		var n [2]byte
+		binary.BigEndian.PutUint16(n[:], uint16(len(tlv.Value)))
+		tlvBytes = append(tlvBytes, n[:]...)
+		tlvBytes = append(tlvBytes, tlv.Value...)
+	}
+
+	b.WriteByte(fam)
+	var length [2]byte
+	binary.BigEndian.PutUint16(length[:], uint16(len(addrs)+len(tlvBytes)))
+	b.Write(length[:])
+	b.Write(addrs)
+	b.Write(tlvBytes)
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// proxyProtocolTLV is a single PROXY protocol v2 type-length-value entry.
+type proxyProtocolTLV struct {
+	Type  byte
+	Value []byte
+}
+
+// clientConn lets serveRtmp consume a PROXY protocol header (or peek the
+// RTMP handshake) through a buffered reader while still exposing the
+// underlying *net.TCPConn's Write/Close/RemoteAddr methods unchanged.
+type clientConn struct {
+	*net.TCPConn
+	r *bufio.Reader
+}
+
+func (v *clientConn) Read(b []byte) (int, error) {
+	return v.r.Read(b)
+}
+
+// trustedProxies wraps a set of CIDRs that are allowed to prepend a PROXY
+// protocol header ahead of an rtmp stream, e.g. an L4 load balancer sitting
+// in front of rtmplb.
+type trustedProxies struct {
+	nets []*net.IPNet
+}
+
+func newTrustedProxies(cidrs []string) (*trustedProxies, error) {
+	v := &trustedProxies{}
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("parse trusted_proxies %v failed, err is %v", c, err)
+		}
+		v.nets = append(v.nets, n)
+	}
+	return v, nil
+}
+
+func (v *trustedProxies) Trusts(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, n := range v.nets {
+		if n.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// readProxyHeader reads and parses an inbound PROXY protocol v1 or v2 header
+// off r, returning the real client address it carries. Only call this once
+// the peer has been confirmed to be a trusted_proxies member.
+func readProxyHeader(r *bufio.Reader) (realClient *net.TCPAddr, err error) {
+	peek, err := r.Peek(len(proxyProtocolV2Signature))
+	if err == nil && bytes.Equal(peek, proxyProtocolV2Signature) {
+		return readProxyProtocolV2(r)
+	}
+	return readProxyProtocolV1(r)
+}
+
+func readProxyProtocolV1(r *bufio.Reader) (*net.TCPAddr, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("read proxy v1 header failed, err is %v", err)
+	}
+
+	var proto, srcIP, dstIP string
+	var srcPort, dstPort int
+	if _, err := fmt.Sscanf(line, "PROXY %s %s %s %d %d", &proto, &srcIP, &dstIP, &srcPort, &dstPort); err != nil {
+		return nil, fmt.Errorf("parse proxy v1 header %q failed, err is %v", line, err)
+	}
+
+	ip := net.ParseIP(srcIP)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid proxy v1 source ip %v", srcIP)
+	}
+	return &net.TCPAddr{IP: ip, Port: srcPort}, nil
+}
+
+func readProxyProtocolV2(r *bufio.Reader) (*net.TCPAddr, error) {
+	header := make([]byte, 16)
+	if _, err := fullRead(r, header); err != nil {
+		return nil, fmt.Errorf("read proxy v2 header failed, err is %v", err)
+	}
+
+	length := int(binary.BigEndian.Uint16(header[14:16]))
+	body := make([]byte, length)
+	if _, err := fullRead(r, body); err != nil {
+		return nil, fmt.Errorf("read proxy v2 body failed, err is %v", err)
+	}
+
+	fam := header[13]
+	switch fam {
+	case pp2FamInet4:
+		if len(body) < 12 {
+			return nil, fmt.Errorf("short proxy v2 ipv4 body")
+		}
+		ip := net.IP(body[0:4])
+		port := binary.BigEndian.Uint16(body[8:10])
+		return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+	case pp2FamInet6:
+		if len(body) < 36 {
+			return nil, fmt.Errorf("short proxy v2 ipv6 body")
+		}
+		ip := net.IP(body[0:16])
+		port := binary.BigEndian.Uint16(body[32:34])
+		return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported proxy v2 family %v", fam)
+	}
+}
+
+func fullRead(r *bufio.Reader, b []byte) (int, error) {
+	n := 0
+	for n < len(b) {
+		m, err := r.Read(b[n:])
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,166 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2016 winlin
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestNormalizeProxyMode(t *testing.T) {
+	cases := []struct {
+		mode         string
+		useRtmpProxy bool
+		want         string
+	}{
+		{"pp2", false, "pp2"},
+		{"pp2", true, "pp2"},
+		{"", true, ProxyModeOryx},
+		{"", false, ProxyModeNone},
+	}
+	for _, c := range cases {
+		if got := normalizeProxyMode(c.mode, c.useRtmpProxy); got != c.want {
+			t.Errorf("normalizeProxyMode(%q, %v) = %q, want %q", c.mode, c.useRtmpProxy, got, c.want)
+		}
+	}
+}
+
+func TestProxyProtocolV1RoundTrip(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}
+	dst := &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 1935}
+
+	var buf bytes.Buffer
+	if err := writeProxyHeader(&buf, ProxyModePP1, src, dst, ""); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	got, err := readProxyProtocolV1(bufio.NewReader(&buf))
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if !got.IP.Equal(src.IP) || got.Port != src.Port {
+		t.Fatalf("got %v, want %v", got, src)
+	}
+}
+
+func TestProxyProtocolV2RoundTrip(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}
+	dst := &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 1935}
+
+	var buf bytes.Buffer
+	if err := writeProxyHeader(&buf, ProxyModePP2, src, dst, ""); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	got, err := readProxyHeader(bufio.NewReader(&buf))
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if !got.IP.Equal(src.IP) || got.Port != src.Port {
+		t.Fatalf("got %v, want %v", got, src)
+	}
+}
+
+func TestProxyProtocolV2RoundTripIPv6(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("fe80::1"), Port: 1234}
+	dst := &net.TCPAddr{IP: net.ParseIP("fe80::2"), Port: 1935}
+
+	var buf bytes.Buffer
+	if err := writeProxyHeader(&buf, ProxyModePP2, src, dst, ""); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	got, err := readProxyHeader(bufio.NewReader(&buf))
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if !got.IP.Equal(src.IP) || got.Port != src.Port {
+		t.Fatalf("got %v, want %v", got, src)
+	}
+}
+
+// TestWriteProxyHeaderPP2CarriesStreamKeyTLV exercises writeProxyHeader's
+// only real caller path for the pp2TlvStreamKey TLV (serveRtmp passing a
+// non-empty streamKey), rather than constructing the TLV by hand against
+// writeProxyProtocolV2 directly.
+func TestWriteProxyHeaderPP2CarriesStreamKeyTLV(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}
+	dst := &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 1935}
+
+	var buf bytes.Buffer
+	if err := writeProxyHeader(&buf, ProxyModePP2, src, dst, "live/stream1"); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	body := buf.Bytes()[16:] // signature(12) + verCmd(1) + fam(1) + length(2)
+	addrLen := 12            // IPv4 src(4) + dst(4) + ports(4)
+	tlv := body[addrLen:]
+	if len(tlv) < 3 || tlv[0] != pp2TlvStreamKey {
+		t.Fatalf("expected a pp2TlvStreamKey TLV, got %v", tlv)
+	}
+	n := int(tlv[1])<<8 | int(tlv[2])
+	if got := string(tlv[3 : 3+n]); got != "live/stream1" {
+		t.Fatalf("tlv value = %q, want %q", got, "live/stream1")
+	}
+}
+
+func TestWriteProxyHeaderNoneIsANoop(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeProxyHeader(&buf, ProxyModeNone, nil, nil, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("got %v bytes, want 0", buf.Len())
+	}
+}
+
+func TestWriteProxyHeaderUnknownModeErrors(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeProxyHeader(&buf, "bogus", nil, nil, ""); err == nil {
+		t.Fatalf("expected an error for an unknown proxy_mode")
+	}
+}
+
+func TestTrustedProxiesTrusts(t *testing.T) {
+	trusted, err := newTrustedProxies([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("newTrustedProxies failed: %v", err)
+	}
+
+	if !trusted.Trusts(&net.TCPAddr{IP: net.ParseIP("10.1.2.3")}) {
+		t.Fatalf("expected 10.1.2.3 to be trusted")
+	}
+	if trusted.Trusts(&net.TCPAddr{IP: net.ParseIP("192.168.1.1")}) {
+		t.Fatalf("expected 192.168.1.1 not to be trusted")
+	}
+}
+
+func TestNewTrustedProxiesRejectsInvalidCIDR(t *testing.T) {
+	if _, err := newTrustedProxies([]string{"not-a-cidr"}); err == nil {
+		t.Fatalf("expected an error for an invalid CIDR")
+	}
+}
@@ -0,0 +1,133 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2016 winlin
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// A single routing rule, matches an RTMP app/stream against a backend pool.
+//
+// Match is a glob expression over "app=<app>/stream=<stream>", for example
+// "app=live/stream=*" routes every stream published to the "live" app. Hash
+// selects how a backend is picked inside Backends once a rule matches; when
+// Hash is "consistent", the stream key always lands on the same backend as
+// long as the pool does not change, otherwise backends are chosen round-robin.
+type RouteRule struct {
+	Match    string   `json:"match"`
+	Backends []string `json:"backends"`
+	Hash     string   `json:"hash,omitempty"`
+}
+
+// matches reports whether the rule applies to the given app/stream pair.
+func (v *RouteRule) matches(app, stream string) bool {
+	key := fmt.Sprintf("app=%v/stream=%v", app, stream)
+	ok, err := path.Match(v.Match, key)
+	return err == nil && ok
+}
+
+// The router picks a backend pool for an RTMP connection based on the
+// app/stream parsed out of the client's connect command, falling back to
+// the global Rtmp.Backend pool when no rule matches.
+type router struct {
+	rules []RouteRule
+}
+
+func NewRouter(rules []RouteRule) *router {
+	return &router{rules: rules}
+}
+
+// Resolve returns the backend to dial for the given app/stream, plus whether
+// a routing rule matched at all. When no rule matches, the caller should fall
+// back to its default backend selection.
+//
+// A matched rule's pool is filtered through reg's availability before a
+// backend is picked, the same way roundRobinBalancer/leastConnBalancer/
+// consistentHashBalancer already filter the default pool — a backend marked
+// down by the prober or draining via the admin API is excluded from routed
+// traffic exactly like it is from unrouted traffic. The consistent-hash ring
+// is therefore rebuilt fresh from the currently-available backends on every
+// call rather than once in NewRouter, mirroring consistentHashBalancer.Pick.
+func (v *router) Resolve(app, stream string, reg *backendRegistry, fallback func(pool []string) string) (backend string, matched bool) {
+	for i := range v.rules {
+		r := &v.rules[i]
+		if len(r.Backends) == 0 || !r.matches(app, stream) {
+			continue
+		}
+
+		available := reg.availableFrom(r.Backends)
+		if strings.EqualFold(r.Hash, "consistent") {
+			return newHashRing(available).Pick(stream), true
+		}
+		return fallback(available), true
+	}
+	return "", false
+}
+
+// A consistent-hash ring over a backend pool, so the same stream key always
+// maps to the same backend as long as the pool is unchanged. Uses a handful
+// of virtual nodes per backend to keep the distribution reasonably even.
+type hashRing struct {
+	nodes    []uint32
+	backends map[uint32]string
+}
+
+const virtualNodesPerBackend = 64
+
+func newHashRing(backends []string) *hashRing {
+	v := &hashRing{backends: map[uint32]string{}}
+	for _, b := range backends {
+		for i := 0; i < virtualNodesPerBackend; i++ {
+			h := ringHash(fmt.Sprintf("%v#%v", b, i))
+			v.nodes = append(v.nodes, h)
+			v.backends[h] = b
+		}
+	}
+	sort.Slice(v.nodes, func(i, j int) bool { return v.nodes[i] < v.nodes[j] })
+	return v
+}
+
+func (v *hashRing) Pick(key string) string {
+	if len(v.nodes) == 0 {
+		return ""
+	}
+
+	h := ringHash(key)
+	i := sort.Search(len(v.nodes), func(i int) bool { return v.nodes[i] >= h })
+	if i == len(v.nodes) {
+		i = 0
+	}
+	return v.backends[v.nodes[i]]
+}
+
+func ringHash(s string) uint32 {
+	sum := sha1.Sum([]byte(s))
+	return binary.BigEndian.Uint32(sum[:4])
+}
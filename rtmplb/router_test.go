@@ -0,0 +1,157 @@
+/*
+The MIT License (MIT)
+
+Copyright (c) 2016 winlin
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import "testing"
+
+func TestRouteRuleMatches(t *testing.T) {
+	r := RouteRule{Match: "app=live/stream=*"}
+
+	if !r.matches("live", "abc") {
+		t.Fatalf("expected app=live/stream=abc to match %v", r.Match)
+	}
+	if r.matches("vod", "abc") {
+		t.Fatalf("expected app=vod/stream=abc not to match %v", r.Match)
+	}
+}
+
+func TestRouterResolveFallsBackToDefaultWhenNoRuleMatches(t *testing.T) {
+	router := NewRouter([]RouteRule{{Match: "app=live/stream=*", Backends: []string{"tcp://a:1"}}})
+	reg := newBackendRegistry([]string{"tcp://a:1"})
+
+	backend, matched := router.Resolve("vod", "abc", reg, func(pool []string) string { return "unused" })
+	if matched || backend != "" {
+		t.Fatalf("got (%q, %v), want (\"\", false)", backend, matched)
+	}
+}
+
+func TestRouterResolveUsesFallbackForNonConsistentRule(t *testing.T) {
+	router := NewRouter([]RouteRule{{Match: "app=live/stream=*", Backends: []string{"tcp://a:1", "tcp://b:2"}}})
+	reg := newBackendRegistry([]string{"tcp://a:1", "tcp://b:2"})
+
+	var gotPool []string
+	backend, matched := router.Resolve("live", "abc", reg, func(pool []string) string {
+		gotPool = pool
+		return pool[0]
+	})
+	if !matched || backend != "tcp://a:1" {
+		t.Fatalf("got (%q, %v), want (tcp://a:1, true)", backend, matched)
+	}
+	if len(gotPool) != 2 {
+		t.Fatalf("fallback saw pool %v, want the rule's 2 backends", gotPool)
+	}
+}
+
+func TestRouterResolveUsesConsistentHashForHashRule(t *testing.T) {
+	router := NewRouter([]RouteRule{{
+		Match:    "app=live/stream=*",
+		Backends: []string{"tcp://a:1", "tcp://b:2", "tcp://c:3"},
+		Hash:     "consistent",
+	}})
+	reg := newBackendRegistry([]string{"tcp://a:1", "tcp://b:2", "tcp://c:3"})
+
+	fallbackCalled := false
+	fallback := func(pool []string) string { fallbackCalled = true; return pool[0] }
+
+	first, matched := router.Resolve("live", "stream1", reg, fallback)
+	if !matched {
+		t.Fatalf("expected rule to match")
+	}
+	if fallbackCalled {
+		t.Fatalf("consistent-hash rule should not use the round-robin fallback")
+	}
+	for i := 0; i < 5; i++ {
+		if again, _ := router.Resolve("live", "stream1", reg, fallback); again != first {
+			t.Fatalf("resolve %v for stream1 = %v, want stable %v", i, again, first)
+		}
+	}
+}
+
+// TestRouterResolveExcludesDrainedAndDownBackends reproduces the gap between
+// allBackendAddrs' doc comment (routed backends get drain enforcement and
+// health-based exclusion "exactly like the default pool") and reality: router
+// previously picked straight from a rule's static Backends with no registry
+// awareness, so a backend marked both draining and down still came back.
+func TestRouterResolveExcludesDrainedAndDownBackends(t *testing.T) {
+	router := NewRouter([]RouteRule{{Match: "app=live/stream=*", Backends: []string{"tcp://a:1", "tcp://b:2"}}})
+	reg := newBackendRegistry([]string{"tcp://a:1", "tcp://b:2"})
+	reg.Get("tcp://a:1").setDraining(true)
+	reg.Get("tcp://a:1").setUp(false)
+
+	for i := 0; i < 10; i++ {
+		backend, matched := router.Resolve("live", "abc", reg, func(pool []string) string { return pool[0] })
+		if !matched {
+			t.Fatalf("expected rule to match")
+		}
+		if backend != "tcp://b:2" {
+			t.Fatalf("got %q, want the only available backend tcp://b:2 (a:1 is drained and down)", backend)
+		}
+	}
+}
+
+func TestRouterResolveConsistentHashExcludesDrainedAndDownBackends(t *testing.T) {
+	router := NewRouter([]RouteRule{{
+		Match:    "app=live/stream=*",
+		Backends: []string{"tcp://a:1", "tcp://b:2"},
+		Hash:     "consistent",
+	}})
+	reg := newBackendRegistry([]string{"tcp://a:1", "tcp://b:2"})
+	reg.Get("tcp://a:1").setDraining(true)
+	reg.Get("tcp://a:1").setUp(false)
+
+	fallback := func(pool []string) string { return "unused" }
+	for i := 0; i < 10; i++ {
+		if backend, _ := router.Resolve("live", "stream1", reg, fallback); backend != "tcp://b:2" {
+			t.Fatalf("got %q, want the only available backend tcp://b:2 (a:1 is drained and down)", backend)
+		}
+	}
+}
+
+func TestHashRingPickIsStableAndCoversAllBackends(t *testing.T) {
+	backends := []string{"tcp://a:1", "tcp://b:2", "tcp://c:3"}
+	ring := newHashRing(backends)
+
+	seen := map[string]bool{}
+	for i := 0; i < 200; i++ {
+		key := string(rune('a' + i%26))
+		seen[ring.Pick(key)] = true
+	}
+	for _, b := range backends {
+		if !seen[b] {
+			t.Fatalf("backend %v never picked across 200 keys: %v", b, seen)
+		}
+	}
+
+	if ring.Pick("same-key") != ring.Pick("same-key") {
+		t.Fatalf("hash ring pick is not stable for a fixed key")
+	}
+}
+
+func TestHashRingPickOnEmptyRingReturnsEmpty(t *testing.T) {
+	ring := newHashRing(nil)
+	if got := ring.Pick("x"); got != "" {
+		t.Fatalf("got %q, want empty", got)
+	}
+}